@@ -18,22 +18,34 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/http"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/hexops/gotextdiff"
 	"github.com/hexops/gotextdiff/myers"
 	"github.com/hexops/gotextdiff/span"
 	"github.com/ohler55/ojg/jp"
 	"github.com/spf13/cobra"
+	"github.com/wI2L/jsondiff"
 	"gopkg.in/yaml.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/rest"
 	watchtools "k8s.io/client-go/tools/watch"
 	cliflag "k8s.io/component-base/cli/flag"
 	"k8s.io/component-base/version/verflag"
@@ -44,6 +56,14 @@ import (
 	"github.com/zoumo/kubectl-plugins/pkg/flags"
 )
 
+// supported values for --output/-o
+const (
+	outputUnified        = "unified"
+	outputJSONPatch      = "json-patch"
+	outputJSONMergePatch = "json-merge-patch"
+	outputNDJSON         = "ndjson"
+)
+
 var example = `
 # Monitor changes in all pods within a specified namespace:
 kubectl watchdiff pods
@@ -65,6 +85,25 @@ kubectl watchdiff pods --jsonpaths="$.status"
 
 # Focus on specific annotation changes within pods:
 kubectl watchdiff pods --jsonpaths="$.metadata.annotations['github.com/zoumo/kubectl-plugins']"
+
+# Continuously diff the same resources between two clusters:
+kubectl watchdiff pods --compare-context=prod
+
+# Stream a live diff of the cluster against a declared baseline:
+kubectl watchdiff pods -f manifests/ --baseline-from-file
+
+# Emit each diff as an RFC 6902 JSON Patch instead of unified text:
+kubectl watchdiff pods -o json-patch
+
+# Persist every diff under a directory, and replay it later:
+kubectl watchdiff pods --log-dir=/var/log/watchdiff
+kubectl watchdiff replay /var/log/watchdiff
+
+# Resume a watch after a laptop sleep or apiserver rollout instead of re-diffing everything:
+kubectl watchdiff pods --state-file=/var/lib/watchdiff/state.json
+
+# See which field manager is responsible for each change:
+kubectl watchdiff pods --by-manager
 `
 
 func main() {
@@ -93,17 +132,44 @@ func newCommand() *cobra.Command {
 	}
 
 	flags.AddFlagsAndUsage(cmd, opt.Flags())
+	cmd.AddCommand(newReplayCommand(opt.Streams))
 	return cmd
 }
 
+func newReplayCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	return &cobra.Command{
+		Use:          "replay <log-dir>",
+		Short:        "Replay diffs previously recorded with --log-dir",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runReplay(streams, args[0])
+		},
+	}
+}
+
 type options struct {
 	Streams              genericclioptions.IOStreams
 	ConfigFlags          *genericclioptions.ConfigFlags
 	ResourceBuilderFlags *genericclioptions.ResourceBuilderFlags
+	CompareConfigFlags   *genericclioptions.ConfigFlags
 
 	JSONPaths            []string
 	IgnoreLabelKeys      []string
 	IgnoreAnnotationKeys []string
+
+	ByManager      bool
+	Managers       []string
+	IgnoreManagers []string
+
+	CompareContext    string
+	CompareKubeconfig string
+
+	BaselineFromFile bool
+
+	Output    string
+	LogDir    string
+	StateFile string
 }
 
 func newOptions() *options {
@@ -115,13 +181,14 @@ func newOptions() *options {
 		WithAll(true).
 		WithAllNamespaces(false).
 		WithLatest()
-	// no need to identifying resources from file
-	resourceBuilder.FileNameFlags = nil
+	// FileNameFlags stays enabled so -f can supply the --baseline-from-file manifests.
 
 	return &options{
 		Streams:              streams,
 		ConfigFlags:          genericclioptions.NewConfigFlags(true),
 		ResourceBuilderFlags: resourceBuilder,
+		CompareConfigFlags:   genericclioptions.NewConfigFlags(true),
+		Output:               outputUnified,
 	}
 }
 
@@ -132,6 +199,17 @@ func (o *options) Flags() *cliflag.NamedFlagSets {
 	fs.StringSliceVar(&o.JSONPaths, "jsonpaths", o.JSONPaths, "Select JSON path expressions to include in the output.")
 	fs.StringSliceVar(&o.IgnoreLabelKeys, "ignore-label-keys", o.IgnoreLabelKeys, "if set, the specified labels will be ignored when comparing objects.")
 	fs.StringSliceVar(&o.IgnoreAnnotationKeys, "ignore-annotation-keys", o.IgnoreLabelKeys, "if set, the specified annotations will be ignored when comparing objects. kubectl.kubernetes.io/last-applied-configuration will be always ignored")
+	fs.StringVarP(&o.Output, "output", "o", o.Output, "output format for each diff. One of: unified|json-patch|json-merge-patch|ndjson.")
+	fs.BoolVar(&o.ByManager, "by-manager", o.ByManager, "if set, attribute fields to their owning field manager (from metadata.managedFields) and render one unified diff section per manager, headed '## manager=<name>', instead of a single flat diff. When no manager's owned fields changed, the single flat diff is rendered. Only applies to -o unified.")
+	fs.StringSliceVar(&o.Managers, "managers", o.Managers, "if set (with --by-manager), restrict the per-manager diff to these field managers.")
+	fs.StringSliceVar(&o.IgnoreManagers, "ignore-managers", o.IgnoreManagers, "if set (with --by-manager), exclude these field managers from the per-manager diff. complements --ignore-annotation-keys.")
+
+	compare := fss.FlagSet("compare")
+	compare.StringVar(&o.CompareContext, "compare-context", o.CompareContext, "if set, watchdiff enters multi-cluster mode and continuously diffs the watched resources against the same resources in this kubeconfig context, instead of diffing against the previous revision.")
+	compare.StringVar(&o.CompareKubeconfig, "compare-kubeconfig", o.CompareKubeconfig, "path to the kubeconfig file used to resolve --compare-context. defaults to the kubeconfig used for the primary cluster.")
+	compare.BoolVar(&o.BaselineFromFile, "baseline-from-file", o.BaselineFromFile, "if set, the manifests supplied via -f are used as the fixed baseline for every diff, and watchdiff streams how the live cluster drifts from them, instead of diffing against the previous revision.")
+	compare.StringVar(&o.LogDir, "log-dir", o.LogDir, "if set, every computed diff is additionally persisted under this directory as <kind>/<namespace>/<name>/<rvBase>-<rvNew>.diff, replayable later with 'kubectl watchdiff replay'.")
+	compare.StringVar(&o.StateFile, "state-file", o.StateFile, "path to a file used to persist the highest observed resource version for the watched GVK, so a later run resumes the watch instead of re-diffing everything. defaults to $XDG_STATE_HOME/kubectl-watchdiff/state.json.")
 
 	o.ResourceBuilderFlags.AddFlags(fss.FlagSet("resource"))
 	o.ConfigFlags.AddFlags(fss.FlagSet("config"))
@@ -140,7 +218,22 @@ func (o *options) Flags() *cliflag.NamedFlagSets {
 }
 
 func (o *options) Complete(args []string) (*config, error) {
-	builder := flags.ToBuilder(o.ResourceBuilderFlags, o.ConfigFlags, args).SingleResourceType()
+	switch o.Output {
+	case outputUnified, outputJSONPatch, outputJSONMergePatch, outputNDJSON:
+	default:
+		return nil, i18n.Errorf("invalid --output %q: must be one of unified|json-patch|json-merge-patch|ndjson", o.Output)
+	}
+
+	// -f only supplies the --baseline-from-file manifests; the watched
+	// resources always come from args.
+	watchFlags := *o.ResourceBuilderFlags
+	watchFlags.FileNameFlags = nil
+
+	builder := flags.ToBuilder(&watchFlags, o.ConfigFlags, args).
+		SingleResourceType().
+		TransformRequests(func(req *rest.Request) {
+			req.Param("allowWatchBookmarks", "true")
+		})
 
 	jsonPaths := []jp.Expr{}
 
@@ -155,41 +248,289 @@ func (o *options) Complete(args []string) (*config, error) {
 	// ignore kubectl applyed annotation key
 	o.IgnoreAnnotationKeys = append(o.IgnoreAnnotationKeys, "kubectl.kubernetes.io/last-applied-configuration")
 
-	return &config{
+	context := "current-context"
+	if o.ConfigFlags.Context != nil && *o.ConfigFlags.Context != "" {
+		context = *o.ConfigFlags.Context
+	} else if rawConfig, err := o.ConfigFlags.ToRawKubeConfigLoader().RawConfig(); err == nil && rawConfig.CurrentContext != "" {
+		context = rawConfig.CurrentContext
+	}
+
+	cfg := &config{
 		IOStreams:            o.Streams,
 		ResourceBuilder:      builder,
+		Context:              context,
+		Output:               o.Output,
+		LogDir:               o.LogDir,
+		StateFile:            o.StateFile,
 		JSONPaths:            jsonPaths,
 		IngoreLabelKeys:      o.IgnoreLabelKeys,
 		IgnoreAnnotationKeys: o.IgnoreAnnotationKeys,
-	}, nil
+		ByManager:            o.ByManager,
+		Managers:             toStringSet(o.Managers),
+		IgnoreManagers:       toStringSet(o.IgnoreManagers),
+	}
+
+	if o.CompareContext != "" {
+		kubeconfig := o.CompareKubeconfig
+		if kubeconfig == "" && o.ConfigFlags.KubeConfig != nil {
+			kubeconfig = *o.ConfigFlags.KubeConfig
+		}
+		o.CompareConfigFlags.Context = &o.CompareContext
+		o.CompareConfigFlags.Namespace = o.ConfigFlags.Namespace
+		if kubeconfig != "" {
+			o.CompareConfigFlags.KubeConfig = &kubeconfig
+		}
+
+		cfg.CompareResourceBuilder = flags.ToBuilder(&watchFlags, o.CompareConfigFlags, args).SingleResourceType()
+		cfg.CompareContext = o.CompareContext
+	}
+
+	if o.BaselineFromFile {
+		if cfg.CompareResourceBuilder != nil {
+			return nil, i18n.Errorf("--baseline-from-file cannot be combined with --compare-context: the compare-context diff never consults the file baseline")
+		}
+
+		baselineInfos, err := loadBaselineInfos(o.ResourceBuilderFlags, o.ConfigFlags)
+		if err != nil {
+			return nil, err
+		}
+		if len(baselineInfos) == 0 {
+			return nil, i18n.Errorf("--baseline-from-file requires -f to specify the baseline manifests")
+		}
+
+		cfg.Baseline = make(map[string]baselineEntry, len(baselineInfos))
+		marshal := cfg.newMarshalFunc()
+		for _, info := range baselineInfos {
+			obj, ok := info.Object.(*unstructured.Unstructured)
+			if !ok {
+				continue
+			}
+			kind := obj.GetObjectKind().GroupVersionKind().Kind
+
+			entry := baselineEntry{}
+			if cfg.ByManager {
+				entry.rawObj = obj.DeepCopy()
+				entry.ownership, _ = managedFieldOwnership(obj)
+			}
+			// marshal strips managed fields/ignored keys and (if --jsonpaths
+			// is set) projects obj in place, so obj itself becomes the
+			// baseline object to diff against, same as the live watch path.
+			entry.yaml = marshal(obj)
+			entry.obj = obj
+			cfg.Baseline[baselineKey(kind, obj.GetNamespace(), obj.GetName())] = entry
+		}
+	}
+
+	return cfg, nil
+}
+
+// loadBaselineInfos resolves the manifests supplied via -f in local mode,
+// without touching the API server, so they can seed the --baseline-from-file
+// diff baseline.
+func loadBaselineInfos(o *genericclioptions.ResourceBuilderFlags, configFlags *genericclioptions.ConfigFlags) ([]*resource.Info, error) {
+	if o.FileNameFlags == nil {
+		return nil, nil
+	}
+
+	namespace, enforceNamespace, err := configFlags.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := o.FileNameFlags.ToOptions()
+	if len(opts.Filenames) == 0 && len(opts.Kustomize) == 0 {
+		return nil, nil
+	}
+
+	return resource.NewBuilder(configFlags).
+		Unstructured().
+		NamespaceParam(namespace).DefaultNamespace().
+		FilenameParam(enforceNamespace, &opts).
+		Local().
+		Flatten().
+		Do().
+		Infos()
+}
+
+func baselineKey(kind, namespace, name string) string {
+	return path.Join(kind, namespace, name)
+}
+
+// baselineEntry is one manifest loaded from -f for --baseline-from-file,
+// primed into a diffObj as its fixed baseline. rawObj/ownership are only
+// populated when --by-manager is set, mirroring the newRawObj/newOwnership
+// captured for live objects in diffWithPrevious.
+type baselineEntry struct {
+	yaml      string
+	obj       *unstructured.Unstructured
+	rawObj    *unstructured.Unstructured
+	ownership fieldOwnership
+}
+
+// toStringSet turns a flag-supplied slice into a lookup set, or nil if the
+// slice is empty so callers can tell "unset" from "empty" with a nil check.
+func toStringSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
 }
 
 type config struct {
 	genericclioptions.IOStreams
-	ResourceBuilder *resource.Builder
+	ResourceBuilder        *resource.Builder
+	Context                string
+	CompareResourceBuilder *resource.Builder
+	CompareContext         string
+	// Baseline maps a baselineKey(kind, namespace, name) to the manifest
+	// loaded from -f when --baseline-from-file is set.
+	Baseline  map[string]baselineEntry
+	Output    string
+	LogDir    string
+	StateFile string
 
 	JSONPaths            []jp.Expr
 	IngoreLabelKeys      []string
 	IgnoreAnnotationKeys []string
+
+	// ByManager switches the unified diff renderer to one section per field
+	// manager (see fieldOwnership), filtered by Managers/IgnoreManagers.
+	ByManager      bool
+	Managers       map[string]bool
+	IgnoreManagers map[string]bool
 }
 
 func (o *config) Run(ctx context.Context) error {
-	r := o.ResourceBuilder.Do()
-	if err := r.Err(); err != nil {
-		return err
+	if o.CompareResourceBuilder != nil {
+		return o.runCompare(ctx)
 	}
+	return o.runSingle(ctx)
+}
+
+// newMarshalFunc builds the marshal function shared by single-cluster and
+// multi-cluster diffing: it strips managed fields, resource version and
+// ignored label/annotation keys, then optionally projects the object down
+// to the requested JSON paths before rendering it as YAML.
+func (o *config) newMarshalFunc() func(*unstructured.Unstructured) string {
+	return func(obj *unstructured.Unstructured) string {
+		// delete managed field
+		obj.SetManagedFields(nil)
+		// delete resource version
+		obj.SetResourceVersion("")
+
+		// delete ignored label keys
+		labels := obj.GetLabels()
+		for _, key := range o.IngoreLabelKeys {
+			delete(labels, key)
+		}
+		obj.SetLabels(labels)
+
+		// delete ignored annotation keys
+		annos := obj.GetAnnotations()
+		for _, key := range o.IgnoreAnnotationKeys {
+			delete(annos, key)
+		}
+		obj.SetAnnotations(annos)
 
-	infos, err := r.Infos()
+		if len(o.JSONPaths) > 0 {
+			projected := map[string]interface{}{}
+			for _, expr := range o.JSONPaths {
+				result := expr.Get(obj.Object)
+				if len(result) > 0 {
+					key := fmt.Sprintf("<fieldByJsonPath = %s>", expr.String())
+					projected[key] = result[0]
+				}
+			}
+			// Project in place so callers holding onto obj (e.g. the
+			// json-patch/json-merge-patch/ndjson output paths, which read
+			// obj.Object directly instead of the rendered YAML) see the
+			// same --jsonpaths-filtered view as the unified diff does.
+			obj.Object = projected
+		}
+
+		d, _ := yaml.Marshal(obj)
+		return string(d)
+	}
+}
+
+// watchState persists, per watched GVK, the highest ResourceVersion
+// watchdiff has observed, so a later invocation can resume the watch from
+// there instead of starting over from "0".
+type watchState struct {
+	ResourceVersions map[string]string `json:"resourceVersions"`
+}
+
+// defaultStateFile mirrors the XDG base directory convention other CLIs
+// (e.g. kubectl itself) use for this kind of run-to-run state.
+func defaultStateFile() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, ".local", "state")
+		}
+	}
+	return filepath.Join(dir, "kubectl-watchdiff", "state.json")
+}
+
+func loadWatchState(path string) (*watchState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &watchState{ResourceVersions: map[string]string{}}, nil
+	}
 	if err != nil {
+		return nil, err
+	}
+	st := &watchState{}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, err
+	}
+	if st.ResourceVersions == nil {
+		st.ResourceVersions = map[string]string{}
+	}
+	return st, nil
+}
+
+func (s *watchState) save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return err
 	}
-	if multipleGVKsRequested(infos) {
-		return i18n.Errorf("watch is only supported on individual resources and resource collections - more than 1 resource was found")
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
 	}
+	return os.WriteFile(path, data, 0o644)
+}
 
-	// set rv to "0" to get first ADDED event
-	rv := "0"
-	w, err := r.Watch(rv)
+func gvkStateKey(gvk schema.GroupVersionKind) string {
+	return gvk.String()
+}
+
+// isResourceExpired reports whether a watch.Error event is the apiserver
+// telling us our resource version has been compacted away (HTTP 410 Gone),
+// in which case the caller must relist instead of retrying the watch.
+func isResourceExpired(event watch.Event) bool {
+	if event.Type != watch.Error {
+		return false
+	}
+	status, ok := event.Object.(*metav1.Status)
+	return ok && status.Code == http.StatusGone
+}
+
+func (o *config) runSingle(ctx context.Context) error {
+	sink, err := buildSink(o.Out, o.Output, o.LogDir)
+	if err != nil {
+		return err
+	}
+
+	stateFile := o.StateFile
+	if stateFile == "" {
+		stateFile = defaultStateFile()
+	}
+	state, err := loadWatchState(stateFile)
 	if err != nil {
 		return err
 	}
@@ -198,102 +539,221 @@ func (o *config) Run(ctx context.Context) error {
 	defer cancel()
 
 	cache := make(map[string]*diffObj)
+	// rv is the resource version the next watch is established from; "0"
+	// means "start fresh and deliver every existing object as an ADD".
+	rv := "0"
+	var stateKey string
+	// firstAttempt gates consulting the saved state file: it must only
+	// happen once, on startup. After a 410 relist we deliberately start
+	// from "0" again rather than re-reading the (now-expired) saved RV.
+	firstAttempt := true
+	// relisting becomes true once we've been through a 410-triggered
+	// relist, so every iteration after the first hydrates the cache from
+	// the fresh list too, not just the initial --state-file resume.
+	relisting := false
 
 	intr := interrupt.New(nil, cancel)
 	// nolint
 	return intr.Run(func() error {
-		_, err := watchtools.UntilWithoutRetry(ctx, w, func(event watch.Event) (bool, error) {
-			obj, ok := event.Object.(*unstructured.Unstructured)
-			if !ok {
-				return true, fmt.Errorf("event.Object is not unstructured.Unstructured")
+		for {
+			r := o.ResourceBuilder.Do()
+			if err := r.Err(); err != nil {
+				return err
 			}
 
-			if event.Type == watch.Error {
-				// print error event
-				fmt.Fprintln(o.ErrOut, obj)
-				return true, nil
+			infos, err := r.Infos()
+			if err != nil {
+				return err
+			}
+			if multipleGVKsRequested(infos) {
+				return i18n.Errorf("watch is only supported on individual resources and resource collections - more than 1 resource was found")
 			}
 
-			uid := string(obj.GetUID())
-			rv := obj.GetResourceVersion()
+			resuming := false
+			if len(infos) > 0 {
+				stateKey = gvkStateKey(infos[0].Mapping.GroupVersionKind)
+				if firstAttempt {
+					if savedRV, ok := state.ResourceVersions[stateKey]; ok && savedRV != "" {
+						rv = savedRV
+						resuming = true
+					}
+				}
+			}
+			firstAttempt = false
 
-			inCache, ok := cache[uid]
-			if !ok {
-				gvk := obj.GetObjectKind().GroupVersionKind()
-				inCache = &diffObj{
-					output:    o.Out,
-					kind:      gvk.Kind,
-					namespace: obj.GetNamespace(),
-					name:      obj.GetName(),
-					marshal: func(obj *unstructured.Unstructured) string {
-						// delete managed field
-						obj.SetManagedFields(nil)
-						// delete resource version
-						obj.SetResourceVersion("")
-
-						// delete ignored label keys
-						labels := obj.GetLabels()
-						for _, key := range o.IngoreLabelKeys {
-							delete(labels, key)
+			if resuming || relisting {
+				// Hydrate the cache from the current list so resuming a
+				// watch (on startup via --state-file, or after a 410
+				// relist) doesn't replay every still-existing object as a
+				// synthetic ADD against an empty baseline.
+				for _, info := range infos {
+					obj, ok := info.Object.(*unstructured.Unstructured)
+					if !ok {
+						continue
+					}
+					uid := string(obj.GetUID())
+					if _, ok := cache[uid]; ok {
+						continue
+					}
+					objRV := obj.GetResourceVersion()
+					gvk := obj.GetObjectKind().GroupVersionKind()
+					d := &diffObj{
+						sink:           sink,
+						kind:           gvk.Kind,
+						apiVersion:     gvk.GroupVersion().String(),
+						namespace:      obj.GetNamespace(),
+						name:           obj.GetName(),
+						uid:            uid,
+						marshal:        o.newMarshalFunc(),
+						byManager:      o.ByManager,
+						managers:       o.Managers,
+						ignoreManagers: o.IgnoreManagers,
+					}
+					if entry, ok := o.Baseline[baselineKey(gvk.Kind, obj.GetNamespace(), obj.GetName())]; ok {
+						d.baseYAML = entry.yaml
+						d.baseObject = entry.obj
+						d.baseRawObject = entry.rawObj
+						d.baseOwnership = entry.ownership
+						d.baseLabel = "file"
+					} else {
+						if o.ByManager {
+							d.baseRawObject = obj.DeepCopy()
+							d.baseOwnership, _ = managedFieldOwnership(obj)
 						}
-						obj.SetLabels(labels)
+						d.baseYAML = d.marshal(obj)
+						d.baseObject = obj
+					}
+					d.rv = objRV
+					cache[uid] = d
+				}
+			}
+
+			w, err := r.Watch(rv)
+			if err != nil {
+				return err
+			}
+
+			expired := false
+			_, err = watchtools.UntilWithoutRetry(ctx, w, func(event watch.Event) (bool, error) {
+				if isResourceExpired(event) {
+					expired = true
+					return true, nil
+				}
 
-						// delete ignored annotation keys
-						annos := obj.GetAnnotations()
-						for _, key := range o.IgnoreAnnotationKeys {
-							delete(annos, key)
+				obj, ok := event.Object.(*unstructured.Unstructured)
+				if !ok {
+					return true, fmt.Errorf("event.Object is not unstructured.Unstructured")
+				}
+
+				if event.Type == watch.Error {
+					// print error event
+					fmt.Fprintln(o.ErrOut, obj)
+					return true, nil
+				}
+
+				newRV := obj.GetResourceVersion()
+
+				if event.Type != watch.Bookmark {
+					uid := string(obj.GetUID())
+
+					inCache, ok := cache[uid]
+					if !ok {
+						gvk := obj.GetObjectKind().GroupVersionKind()
+						inCache = &diffObj{
+							sink:           sink,
+							kind:           gvk.Kind,
+							apiVersion:     gvk.GroupVersion().String(),
+							namespace:      obj.GetNamespace(),
+							name:           obj.GetName(),
+							uid:            uid,
+							marshal:        o.newMarshalFunc(),
+							byManager:      o.ByManager,
+							managers:       o.Managers,
+							ignoreManagers: o.IgnoreManagers,
 						}
-						obj.SetAnnotations(annos)
-
-						if len(o.JSONPaths) > 0 {
-							newObj := &unstructured.Unstructured{
-								Object: map[string]interface{}{},
-							}
-							for _, expr := range o.JSONPaths {
-								result := expr.Get(obj.Object)
-								if len(result) > 0 {
-									key := fmt.Sprintf("<fieldByJsonPath = %s>", expr.String())
-									newObj.Object[key] = result[0]
-								}
-							}
-							obj = newObj
+						if entry, ok := o.Baseline[baselineKey(gvk.Kind, obj.GetNamespace(), obj.GetName())]; ok {
+							inCache.baseYAML = entry.yaml
+							inCache.baseObject = entry.obj
+							inCache.baseRawObject = entry.rawObj
+							inCache.baseOwnership = entry.ownership
+							inCache.baseLabel = "file"
 						}
+						cache[uid] = inCache
+						klog.InfoS("start watching diff of resource",
+							"apiVersion", gvk.GroupVersion().String(),
+							"kind", gvk.Kind,
+							"namespace", inCache.namespace,
+							"name", inCache.name,
+							"rv", newRV,
+						)
+					}
 
-						d, _ := yaml.Marshal(obj)
-						return string(d)
-					},
+					inCache.diffWithPrevious(event.Type, newRV, obj)
+
+					if event.Type == watch.Deleted {
+						delete(cache, uid)
+					}
 				}
-				cache[uid] = inCache
-				klog.InfoS("start watching diff of resource",
-					"apiVersion", gvk.GroupVersion().String(),
-					"kind", gvk.Kind,
-					"namespace", inCache.namespace,
-					"name", inCache.name,
-					"rv", rv,
-				)
-			}
 
-			inCache.diffWithPrevious(rv, obj)
+				rv = newRV
+				if stateKey != "" {
+					// Initial ADD events are not delivered in RV order, so only
+					// move the saved RV forward; non-numeric RVs always replace it.
+					savedRV, savedErr := strconv.ParseUint(state.ResourceVersions[stateKey], 10, 64)
+					eventRV, eventErr := strconv.ParseUint(newRV, 10, 64)
+					if savedErr != nil || eventErr != nil || eventRV > savedRV {
+						state.ResourceVersions[stateKey] = newRV
+						if err := state.save(stateFile); err != nil {
+							klog.ErrorS(err, "failed to persist watch state", "stateFile", stateFile)
+						}
+					}
+				}
 
-			if event.Type == watch.Deleted {
-				delete(cache, uid)
+				return false, nil
+			})
+			if err != nil {
+				return err
+			}
+			if !expired {
+				return nil
 			}
 
-			return false, nil
-		})
-		return err
+			klog.InfoS("watch resource version expired, relisting", "gvk", stateKey)
+			cache = make(map[string]*diffObj)
+			rv = "0"
+			relisting = true
+		}
 	})
 }
 
 type diffObj struct {
 	kind       string
+	apiVersion string
 	namespace  string
 	name       string
+	uid        string
 	rv         string
 	marshal    func(*unstructured.Unstructured) string
-	output     io.Writer
+	sink       DiffSink
 	baseObject *unstructured.Unstructured
 	baseYAML   string
+	// baseLabel overrides the revision label used for the base side of every
+	// diff. It is set to "file" when baseYAML was primed from
+	// --baseline-from-file, in which case baseObject/baseYAML stay pinned to
+	// the declared manifest for the lifetime of the object rather than
+	// rolling forward to the previous live revision, so every event keeps
+	// diffing against the same fixed "left" side.
+	baseLabel string
+
+	// byManager, managers and ignoreManagers configure --by-manager
+	// rendering. baseOwnership/baseRawObject are the previous revision's
+	// managedFields ownership and a pre-strip copy of the object itself,
+	// captured before marshal removes managedFields for the normal path.
+	byManager      bool
+	managers       map[string]bool
+	ignoreManagers map[string]bool
+	baseOwnership  fieldOwnership
+	baseRawObject  *unstructured.Unstructured
 }
 
 func (o *diffObj) getNamePath(rv string) string {
@@ -303,38 +763,91 @@ func (o *diffObj) getNamePath(rv string) string {
 	return path.Join(o.kind, o.namespace, o.name, rv)
 }
 
-func (o *diffObj) diffWithPrevious(newRV string, newObj *unstructured.Unstructured) {
+func (o *diffObj) diffWithPrevious(eventType watch.EventType, newRV string, newObj *unstructured.Unstructured) {
+	// managedFields ownership must be captured before marshal strips it.
+	var newOwnership fieldOwnership
+	var newRawObj *unstructured.Unstructured
+	if o.byManager {
+		newRawObj = newObj.DeepCopy()
+		newOwnership, _ = managedFieldOwnership(newObj)
+	}
+
 	newYaml := o.marshal(newObj)
 
 	baseRV := o.rv
 	baseYaml := o.baseYAML
+	baseObj := o.baseObject
+	if o.baseLabel != "" {
+		baseRV = o.baseLabel
+	}
 
 	// ignore if the new coming obj is the same as previous one
 	if o.rv == newRV {
 		return
 	}
 
-	o.diff(baseRV, baseYaml, newRV, newYaml)
-	o.baseObject = newObj
-	o.baseYAML = newYaml
+	o.diff(eventType, baseRV, baseYaml, baseObj, newRV, newYaml, newObj, o.baseOwnership, newOwnership, o.baseRawObject, newRawObj)
 	o.rv = newRV
+	if o.baseLabel != "file" {
+		// Not pinned to a declared manifest: the previous live revision
+		// becomes the new baseline, same as ordinary RV-to-RV diffing.
+		o.baseObject = newObj
+		o.baseYAML = newYaml
+		o.baseOwnership = newOwnership
+		o.baseRawObject = newRawObj
+	}
 }
 
-func (o diffObj) diff(rvBase, baseYaml, rvDiff, diffYaml string) {
+func (o diffObj) diff(eventType watch.EventType, rvBase, baseYaml string, baseObj *unstructured.Unstructured, rvDiff, diffYaml string, newObj *unstructured.Unstructured, baseOwnership, newOwnership fieldOwnership, baseRawObj, newRawObj *unstructured.Unstructured) {
 	if len(rvBase) == 0 {
 		rvBase = "0"
 	}
-	edits := myers.ComputeEdits(span.URIFromPath(""), baseYaml, diffYaml)
-	baseName := o.getNamePath(rvBase)
-	newName := o.getNamePath(rvDiff)
 
-	unified := gotextdiff.ToUnified(baseName, newName, baseYaml, edits)
-	diffStr := fmt.Sprint(unified)
+	d := Diff{
+		EventType:  eventType,
+		APIVersion: o.apiVersion,
+		Kind:       o.kind,
+		Namespace:  o.namespace,
+		Name:       o.name,
+		UID:        o.uid,
+		BaseRV:     rvBase,
+		NewRV:      rvDiff,
+		BaseName:   o.getNamePath(rvBase),
+		NewName:    o.getNamePath(rvDiff),
+		BaseObj:    baseObj,
+		NewObj:     newObj,
+		BaseYAML:   baseYaml,
+		NewYAML:    diffYaml,
+	}
+	if o.byManager {
+		d.UnifiedText = diffByManager(d.BaseName, d.NewName, baseRawObj, newRawObj, baseOwnership, newOwnership, o.managers, o.ignoreManagers)
+	}
+
+	err := o.sink.Write(d)
+	if err != nil {
+		klog.ErrorS(err, "failed to write diff", "kind", o.kind, "namespace", o.namespace, "name", o.name)
+	}
+}
 
+// printUnifiedDiff renders a colorized unified diff between baseYaml and
+// diffYaml to output, labelling the two sides with baseName and diffName.
+func printUnifiedDiff(output io.Writer, baseName, diffName, baseYaml, diffYaml string) {
+	diffStr := unifiedDiffText(baseName, diffName, baseYaml, diffYaml)
 	if len(diffStr) == 0 {
 		return
 	}
+	writeColorized(output, diffStr)
+}
 
+// unifiedDiffText renders the plain (uncolored) unified diff text, as
+// persisted to disk by fileSink and later recolored by 'watchdiff replay'.
+func unifiedDiffText(baseName, diffName, baseYaml, diffYaml string) string {
+	edits := myers.ComputeEdits(span.URIFromPath(""), baseYaml, diffYaml)
+	unified := gotextdiff.ToUnified(baseName, diffName, baseYaml, edits)
+	return fmt.Sprint(unified)
+}
+
+func writeColorized(output io.Writer, diffStr string) {
 	lines := strings.Split(diffStr, "\n")
 	// print color
 	for _, line := range lines {
@@ -347,8 +860,688 @@ func (o diffObj) diff(rvBase, baseYaml, rvDiff, diffYaml string) {
 				line = fmt.Sprintf("\x1b[31m%s\x1b[0m", line)
 			}
 		}
-		fmt.Fprintln(o.output, line)
+		fmt.Fprintln(output, line)
+	}
+}
+
+// fieldOwnership maps a field manager name to its FieldsV1 ownership tree,
+// parsed straight from metadata.managedFields. Trees use the structured-merge
+// encoding: "f:<name>" map keys descend into an object field, "k:<json>" list
+// keys select an item by its identifying fields, "v:<json>" list keys select
+// a scalar/set-style item by its own value, and a bare "." marks the node
+// itself (and everything under it, for our purposes) as owned.
+type fieldOwnership map[string]map[string]interface{}
+
+// managedFieldOwnership parses obj's metadata.managedFields into a
+// fieldOwnership, merging multiple entries for the same manager (e.g. one
+// per subresource) into a single tree.
+func managedFieldOwnership(obj *unstructured.Unstructured) (fieldOwnership, error) {
+	owners := fieldOwnership{}
+	for _, mf := range obj.GetManagedFields() {
+		if mf.FieldsV1 == nil {
+			continue
+		}
+		var node map[string]interface{}
+		if err := json.Unmarshal(mf.FieldsV1.Raw, &node); err != nil {
+			return nil, err
+		}
+		owners[mf.Manager] = mergeFieldNodes(owners[mf.Manager], node)
+	}
+	return owners, nil
+}
+
+func mergeFieldNodes(a, b map[string]interface{}) map[string]interface{} {
+	if a == nil {
+		return b
+	}
+	for k, v := range b {
+		bm, bIsMap := v.(map[string]interface{})
+		am, aIsMap := a[k].(map[string]interface{})
+		if bIsMap && aIsMap {
+			a[k] = mergeFieldNodes(am, bm)
+			continue
+		}
+		a[k] = v
+	}
+	return a
+}
+
+// projectFields returns the subset of obj owned by node, mirroring obj's own
+// structure. Maps recurse field by field; lists keep only items matched by a
+// "k:" object selector or a "v:" scalar-value selector.
+func projectFields(obj interface{}, node map[string]interface{}) interface{} {
+	if node == nil {
+		return nil
+	}
+	if _, ok := node["."]; ok {
+		return obj
+	}
+	switch o := obj.(type) {
+	case map[string]interface{}:
+		result := map[string]interface{}{}
+		for key, child := range node {
+			name := strings.TrimPrefix(key, "f:")
+			val, ok := o[name]
+			if !ok {
+				continue
+			}
+			childNode, ok := child.(map[string]interface{})
+			if !ok {
+				result[name] = val
+				continue
+			}
+			result[name] = projectFields(val, childNode)
+		}
+		return result
+	case []interface{}:
+		var result []interface{}
+		for _, item := range o {
+			if listItemOwned(item, node) {
+				result = append(result, item)
+			}
+		}
+		return result
+	default:
+		return obj
+	}
+}
+
+func listItemOwned(item interface{}, node map[string]interface{}) bool {
+	for key := range node {
+		switch {
+		case strings.HasPrefix(key, "k:"):
+			var selector map[string]interface{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(key, "k:")), &selector); err != nil {
+				continue
+			}
+			m, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			matched := true
+			for k, v := range selector {
+				if fmt.Sprint(m[k]) != fmt.Sprint(v) {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				return true
+			}
+		case strings.HasPrefix(key, "v:"):
+			// Scalar/set-style list items (e.g. metadata.finalizers) are
+			// keyed by their own JSON-encoded value rather than an object
+			// selector, so match item against the decoded value directly.
+			var value interface{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(key, "v:")), &value); err != nil {
+				continue
+			}
+			if fmt.Sprint(item) == fmt.Sprint(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// diffByManager renders one unified-diff section per field manager that owns
+// part of baseObj or newObj, headed "## manager=<name>", instead of a single
+// flat diff. managers/ignoreManagers, if non-nil, restrict which managers get
+// a section. Managers whose owned subtree didn't change are omitted.
+func diffByManager(baseName, diffName string, baseObj, newObj *unstructured.Unstructured, baseOwnership, newOwnership fieldOwnership, managers, ignoreManagers map[string]bool) string {
+	names := map[string]bool{}
+	for m := range baseOwnership {
+		names[m] = true
+	}
+	for m := range newOwnership {
+		names[m] = true
+	}
+
+	sorted := make([]string, 0, len(names))
+	for m := range names {
+		if managers != nil && !managers[m] {
+			continue
+		}
+		if ignoreManagers[m] {
+			continue
+		}
+		sorted = append(sorted, m)
+	}
+	sort.Strings(sorted)
+
+	var sections []string
+	for _, manager := range sorted {
+		baseYaml := projectManagerYAML(baseObj, baseOwnership[manager])
+		newYaml := projectManagerYAML(newObj, newOwnership[manager])
+		diffStr := unifiedDiffText(baseName, diffName, baseYaml, newYaml)
+		if len(diffStr) == 0 {
+			continue
+		}
+		sections = append(sections, fmt.Sprintf("## manager=%s\n%s", manager, diffStr))
+	}
+	return strings.Join(sections, "\n")
+}
+
+func projectManagerYAML(obj *unstructured.Unstructured, node map[string]interface{}) string {
+	if obj == nil || node == nil {
+		return ""
+	}
+	d, _ := yaml.Marshal(projectFields(obj.Object, node))
+	return string(d)
+}
+
+// Diff is the fully computed representation of a single diff event, passed
+// to a DiffSink for rendering. BaseName/NewName are pretty path labels for
+// unified-diff headers; BaseRV/NewRV are the raw revision (or, in
+// --compare-context mode, cluster context) identifiers, and BaseRV is
+// "file" when the base side is a --baseline-from-file manifest.
+type Diff struct {
+	EventType  watch.EventType
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+	UID        string
+	BaseRV     string
+	NewRV      string
+	BaseName   string
+	NewName    string
+	BaseObj    *unstructured.Unstructured
+	NewObj     *unstructured.Unstructured
+	BaseYAML   string
+	NewYAML    string
+	// UnifiedText, when non-empty, is pre-rendered text that a unified-diff
+	// sink should use verbatim instead of diffing BaseYAML/NewYAML itself
+	// (e.g. the per-manager sections produced by --by-manager).
+	UnifiedText string
+}
+
+// DiffSink receives every computed diff. The stdout printer is one
+// implementation, a filesystem writer (fileSink) is another, and multiSink
+// composes any number of them so a single run can do both at once.
+type DiffSink interface {
+	Write(d Diff) error
+}
+
+// stdoutSink renders each diff to an io.Writer using the --output format.
+type stdoutSink struct {
+	output io.Writer
+	format string
+}
+
+func (s *stdoutSink) Write(d Diff) error {
+	switch s.format {
+	case outputJSONPatch:
+		writeJSONPatch(s.output, d.Kind, d.Namespace, d.Name, d.BaseRV, d.NewRV, d.BaseObj, d.NewObj)
+	case outputJSONMergePatch:
+		writeJSONMergePatch(s.output, d.Kind, d.Namespace, d.Name, d.BaseRV, d.NewRV, d.BaseObj, d.NewObj)
+	case outputNDJSON:
+		writeNDJSON(s.output, d.EventType, d.Kind, d.Namespace, d.Name, d.BaseRV, d.NewRV, d.BaseObj, d.NewObj)
+	default:
+		if d.UnifiedText != "" {
+			writeColorized(s.output, d.UnifiedText)
+			return nil
+		}
+		printUnifiedDiff(s.output, d.BaseName, d.NewName, d.BaseYAML, d.NewYAML)
+	}
+	return nil
+}
+
+// diffMeta is written once per object, as <log-dir>/<kind>/<namespace>/<name>/meta.json.
+type diffMeta struct {
+	APIVersion string    `json:"apiVersion"`
+	Kind       string    `json:"kind"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Name       string    `json:"name"`
+	UID        string    `json:"uid,omitempty"`
+	FirstSeen  time.Time `json:"firstSeen"`
+}
+
+// fileSink persists every diff under <dir>/<kind>/<namespace>/<name>/<rvBase>-<rvNew>.diff,
+// alongside a meta.json recording the object's GVK/UID/first-seen timestamp.
+type fileSink struct {
+	dir  string
+	seen map[string]bool
+}
+
+func newFileSink(dir string) *fileSink {
+	return &fileSink{dir: dir, seen: map[string]bool{}}
+}
+
+func (s *fileSink) Write(d Diff) error {
+	diffText := d.UnifiedText
+	if diffText == "" {
+		diffText = unifiedDiffText(d.BaseName, d.NewName, d.BaseYAML, d.NewYAML)
+	}
+	if len(diffText) == 0 {
+		return nil
+	}
+
+	objDir := filepath.Join(s.dir, d.Kind, d.Namespace, d.Name)
+	if err := os.MkdirAll(objDir, 0o755); err != nil {
+		return err
+	}
+
+	key := path.Join(d.Kind, d.Namespace, d.Name)
+	metaPath := filepath.Join(objDir, "meta.json")
+	if _, err := os.Stat(metaPath); err == nil {
+		// recorded by an earlier run into the same log dir; keep its firstSeen.
+		s.seen[key] = true
+	}
+	if !s.seen[key] {
+		data, err := json.MarshalIndent(diffMeta{
+			APIVersion: d.APIVersion,
+			Kind:       d.Kind,
+			Namespace:  d.Namespace,
+			Name:       d.Name,
+			UID:        d.UID,
+			FirstSeen:  time.Now().UTC(),
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(metaPath, data, 0o644); err != nil {
+			return err
+		}
+		s.seen[key] = true
+	}
+
+	rvBase := d.BaseRV
+	if rvBase == "" {
+		rvBase = "0"
+	}
+	fileName := fmt.Sprintf("%s-%s.diff", sanitizeRVForFilename(rvBase), sanitizeRVForFilename(d.NewRV))
+	return os.WriteFile(filepath.Join(objDir, fileName), []byte(diffText), 0o644)
+}
+
+func sanitizeRVForFilename(rv string) string {
+	return strings.ReplaceAll(rv, "/", "_")
+}
+
+// multiSink fans a single diff out to several sinks, e.g. stdout and a log
+// directory at the same time.
+type multiSink struct {
+	sinks []DiffSink
+}
+
+func (m multiSink) Write(d Diff) error {
+	for _, sink := range m.sinks {
+		if err := sink.Write(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildSink assembles the DiffSink for a run: stdout always, plus a
+// fileSink under logDir when --log-dir is set.
+func buildSink(out io.Writer, format, logDir string) (DiffSink, error) {
+	sinks := []DiffSink{&stdoutSink{output: out, format: format}}
+	if logDir != "" {
+		if err := os.MkdirAll(logDir, 0o755); err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, newFileSink(logDir))
+	}
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+	return multiSink{sinks: sinks}, nil
+}
+
+// runReplay walks a --log-dir directory in chronological (file mtime) order
+// and reprints each recorded diff to stdout with the same colorization as
+// live mode.
+func runReplay(streams genericclioptions.IOStreams, dir string) error {
+	type entry struct {
+		path    string
+		modTime time.Time
+	}
+
+	var entries []entry
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(p, ".diff") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{path: p, modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	for _, e := range entries {
+		data, err := os.ReadFile(e.path)
+		if err != nil {
+			return err
+		}
+		writeColorized(streams.Out, string(data))
+	}
+	return nil
+}
+
+// computeJSONPatch computes the RFC 6902 JSON Patch that turns baseObj into
+// newObj, operating on the unstructured object graphs directly rather than
+// diffing their marshalled YAML text. baseObj may be nil, meaning "absent".
+func computeJSONPatch(baseObj, newObj *unstructured.Unstructured) (jsondiff.Patch, error) {
+	var before interface{} = map[string]interface{}{}
+	if baseObj != nil {
+		before = baseObj.Object
+	}
+	return jsondiff.Compare(before, newObj.Object)
+}
+
+// writeJSONPatch renders the diff between baseObj and newObj as an RFC 6902
+// JSON Patch document.
+func writeJSONPatch(output io.Writer, kind, namespace, name, baseRV, newRV string, baseObj, newObj *unstructured.Unstructured) {
+	patch, err := computeJSONPatch(baseObj, newObj)
+	if err != nil {
+		klog.ErrorS(err, "failed to compute json-patch", "kind", kind, "namespace", namespace, "name", name)
+		return
+	}
+	if len(patch) == 0 {
+		return
+	}
+	data, err := json.MarshalIndent(patch, "", "  ")
+	if err != nil {
+		klog.ErrorS(err, "failed to marshal json-patch", "kind", kind, "namespace", namespace, "name", name)
+		return
+	}
+	fmt.Fprintf(output, "## %s/%s -> %s/%s\n%s\n", kind, baseRV, kind, newRV, data)
+}
+
+// marshalPair renders baseObj (or an empty object, if nil) and newObj as
+// JSON, for use by RFC 7396 JSON Merge Patch computation.
+func marshalPair(baseObj, newObj *unstructured.Unstructured) ([]byte, []byte, error) {
+	before := map[string]interface{}{}
+	if baseObj != nil {
+		before = baseObj.Object
+	}
+	beforeJSON, err := json.Marshal(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	newJSON, err := json.Marshal(newObj.Object)
+	if err != nil {
+		return nil, nil, err
+	}
+	return beforeJSON, newJSON, nil
+}
+
+// writeJSONMergePatch renders the diff between baseObj and newObj as an
+// RFC 7396 JSON Merge Patch document.
+func writeJSONMergePatch(output io.Writer, kind, namespace, name, baseRV, newRV string, baseObj, newObj *unstructured.Unstructured) {
+	beforeJSON, newJSON, err := marshalPair(baseObj, newObj)
+	if err != nil {
+		klog.ErrorS(err, "failed to marshal objects for json-merge-patch", "kind", kind, "namespace", namespace, "name", name)
+		return
+	}
+	patch, err := jsonpatch.CreateMergePatch(beforeJSON, newJSON)
+	if err != nil {
+		klog.ErrorS(err, "failed to compute json-merge-patch", "kind", kind, "namespace", namespace, "name", name)
+		return
+	}
+	if string(patch) == "{}" {
+		return
+	}
+	fmt.Fprintf(output, "## %s/%s -> %s/%s\n%s\n", kind, baseRV, kind, newRV, patch)
+}
+
+// ndjsonEvent is the envelope written per diff when -o ndjson is selected,
+// one JSON object per line, suitable for piping into jq/grep/alerting.
+type ndjsonEvent struct {
+	Event     string      `json:"event"`
+	Kind      string      `json:"kind"`
+	Namespace string      `json:"namespace,omitempty"`
+	Name      string      `json:"name"`
+	BaseRV    string      `json:"baseRV"`
+	NewRV     string      `json:"newRV"`
+	Patch     interface{} `json:"patch"`
+}
+
+func writeNDJSON(output io.Writer, eventType watch.EventType, kind, namespace, name, baseRV, newRV string, baseObj, newObj *unstructured.Unstructured) {
+	patch, err := computeJSONPatch(baseObj, newObj)
+	if err != nil {
+		klog.ErrorS(err, "failed to compute patch for ndjson output", "kind", kind, "namespace", namespace, "name", name)
+		return
+	}
+
+	data, err := json.Marshal(ndjsonEvent{
+		Event:     string(eventType),
+		Kind:      kind,
+		Namespace: namespace,
+		Name:      name,
+		BaseRV:    baseRV,
+		NewRV:     newRV,
+		Patch:     patch,
+	})
+	if err != nil {
+		klog.ErrorS(err, "failed to marshal ndjson event", "kind", kind, "namespace", namespace, "name", name)
+		return
+	}
+	fmt.Fprintln(output, string(data))
+}
+
+// compareObj tracks the last seen YAML rendering of a resource on both
+// sides of a --compare-context run, keyed by (namespace, name) since UIDs
+// are cluster-local and differ between the two clusters being compared.
+type compareObj struct {
+	kind       string
+	apiVersion string
+	namespace  string
+	name       string
+	sink       DiffSink
+	leftLabel  string
+	rightLabel string
+	leftYAML   string
+	rightYAML  string
+	leftObj    *unstructured.Unstructured
+	rightObj   *unstructured.Unstructured
+	seenLeft   bool
+	seenRight  bool
+
+	// byManager, managers and ignoreManagers configure --by-manager
+	// rendering; leftOwnership/rightOwnership and leftRawObj/rightRawObj are
+	// the managedFields ownership and pre-strip copy of each side, captured
+	// before marshal removes managedFields for the normal path.
+	byManager      bool
+	managers       map[string]bool
+	ignoreManagers map[string]bool
+	leftOwnership  fieldOwnership
+	rightOwnership fieldOwnership
+	leftRawObj     *unstructured.Unstructured
+	rightRawObj    *unstructured.Unstructured
+}
+
+func (o *compareObj) getNamePath(label string) string {
+	if o.namespace == "" {
+		return path.Join(label, o.kind, o.name)
+	}
+	return path.Join(label, o.kind, o.namespace, o.name)
+}
+
+// update records the latest object/YAML for the given side and re-diffs the
+// two sides whenever both have been observed at least once. ownership and
+// rawObj are only meaningful (and only passed) when byManager is set.
+func (o *compareObj) update(left bool, obj *unstructured.Unstructured, yaml string, ownership fieldOwnership, rawObj *unstructured.Unstructured) {
+	if left {
+		o.leftObj = obj
+		o.leftYAML = yaml
+		o.seenLeft = true
+		o.leftOwnership = ownership
+		o.leftRawObj = rawObj
+	} else {
+		o.rightObj = obj
+		o.rightYAML = yaml
+		o.seenRight = true
+		o.rightOwnership = ownership
+		o.rightRawObj = rawObj
+	}
+
+	if !o.seenLeft || !o.seenRight {
+		return
+	}
+
+	d := Diff{
+		APIVersion: o.apiVersion,
+		Kind:       o.kind,
+		Namespace:  o.namespace,
+		Name:       o.name,
+		BaseRV:     o.leftLabel,
+		NewRV:      o.rightLabel,
+		BaseName:   o.getNamePath(o.leftLabel),
+		NewName:    o.getNamePath(o.rightLabel),
+		BaseObj:    o.leftObj,
+		NewObj:     o.rightObj,
+		BaseYAML:   o.leftYAML,
+		NewYAML:    o.rightYAML,
+	}
+	if o.byManager {
+		d.UnifiedText = diffByManager(d.BaseName, d.NewName, o.leftRawObj, o.rightRawObj, o.leftOwnership, o.rightOwnership, o.managers, o.ignoreManagers)
+	}
+
+	err := o.sink.Write(d)
+	if err != nil {
+		klog.ErrorS(err, "failed to write diff", "kind", o.kind, "namespace", o.namespace, "name", o.name)
+	}
+}
+
+func compareCacheKey(namespace, name string) string {
+	return path.Join(namespace, name)
+}
+
+// runCompare watches the same resource set in two clusters simultaneously
+// and streams the diff between them, instead of diffing consecutive
+// revisions within a single cluster.
+func (o *config) runCompare(ctx context.Context) error {
+	left := o.ResourceBuilder.Do()
+	if err := left.Err(); err != nil {
+		return err
+	}
+	right := o.CompareResourceBuilder.Do()
+	if err := right.Err(); err != nil {
+		return err
+	}
+
+	leftInfos, err := left.Infos()
+	if err != nil {
+		return err
+	}
+	rightInfos, err := right.Infos()
+	if err != nil {
+		return err
+	}
+	if multipleGVKsRequested(leftInfos) || multipleGVKsRequested(rightInfos) {
+		return i18n.Errorf("watch is only supported on individual resources and resource collections - more than 1 resource was found")
+	}
+
+	sink, err := buildSink(o.Out, o.Output, o.LogDir)
+	if err != nil {
+		return err
+	}
+
+	leftWatch, err := left.Watch("0")
+	if err != nil {
+		return err
+	}
+	rightWatch, err := right.Watch("0")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	leftLabel := o.Context
+	rightLabel := o.CompareContext
+
+	cache := make(map[string]*compareObj)
+	marshal := o.newMarshalFunc()
+
+	handle := func(isLeft bool, event watch.Event) error {
+		obj, ok := event.Object.(*unstructured.Unstructured)
+		if !ok {
+			return fmt.Errorf("event.Object is not unstructured.Unstructured")
+		}
+
+		if event.Type == watch.Error {
+			fmt.Fprintln(o.ErrOut, obj)
+			return nil
+		}
+
+		key := compareCacheKey(obj.GetNamespace(), obj.GetName())
+		inCache, ok := cache[key]
+		if !ok {
+			gvk := obj.GetObjectKind().GroupVersionKind()
+			inCache = &compareObj{
+				sink:           sink,
+				kind:           gvk.Kind,
+				apiVersion:     gvk.GroupVersion().String(),
+				namespace:      obj.GetNamespace(),
+				name:           obj.GetName(),
+				leftLabel:      leftLabel,
+				rightLabel:     rightLabel,
+				byManager:      o.ByManager,
+				managers:       o.Managers,
+				ignoreManagers: o.IgnoreManagers,
+			}
+			cache[key] = inCache
+		}
+
+		if event.Type == watch.Deleted {
+			delete(cache, key)
+			return nil
+		}
+
+		// managedFields ownership must be captured before marshal strips it.
+		var ownership fieldOwnership
+		var rawObj *unstructured.Unstructured
+		if o.ByManager {
+			rawObj = obj.DeepCopy()
+			ownership, _ = managedFieldOwnership(obj)
+		}
+
+		inCache.update(isLeft, obj, marshal(obj), ownership, rawObj)
+		return nil
 	}
+
+	intr := interrupt.New(nil, cancel)
+	// nolint
+	return intr.Run(func() error {
+		leftCh := leftWatch.ResultChan()
+		rightCh := rightWatch.ResultChan()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case event, ok := <-leftCh:
+				if !ok {
+					return fmt.Errorf("watch channel for %q closed unexpectedly", leftLabel)
+				}
+				if err := handle(true, event); err != nil {
+					return err
+				}
+			case event, ok := <-rightCh:
+				if !ok {
+					return fmt.Errorf("watch channel for %q closed unexpectedly", rightLabel)
+				}
+				if err := handle(false, event); err != nil {
+					return err
+				}
+			}
+		}
+	})
 }
 
 func multipleGVKsRequested(infos []*resource.Info) bool {
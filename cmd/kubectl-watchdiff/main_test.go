@@ -0,0 +1,164 @@
+/**
+ * Copyright 2024 jim.zoumo@gmail.com
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestListItemOwned(t *testing.T) {
+	tests := []struct {
+		name string
+		item interface{}
+		node map[string]interface{}
+		want bool
+	}{
+		{
+			name: "k selector matches owned container",
+			item: map[string]interface{}{"name": "app", "image": "nginx"},
+			node: map[string]interface{}{
+				`k:{"name":"app"}`: map[string]interface{}{".": struct{}{}},
+			},
+			want: true,
+		},
+		{
+			name: "k selector does not match other container",
+			item: map[string]interface{}{"name": "sidecar", "image": "nginx"},
+			node: map[string]interface{}{
+				`k:{"name":"app"}`: map[string]interface{}{".": struct{}{}},
+			},
+			want: false,
+		},
+		{
+			name: "v selector matches owned finalizer",
+			item: "kubernetes.io/foo",
+			node: map[string]interface{}{
+				`v:"kubernetes.io/foo"`: struct{}{},
+			},
+			want: true,
+		},
+		{
+			name: "v selector does not match other finalizer",
+			item: "kubernetes.io/bar",
+			node: map[string]interface{}{
+				`v:"kubernetes.io/foo"`: struct{}{},
+			},
+			want: false,
+		},
+		{
+			name: "no k or v keys",
+			item: "kubernetes.io/foo",
+			node: map[string]interface{}{},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := listItemOwned(tt.item, tt.node); got != tt.want {
+				t.Errorf("listItemOwned() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProjectFields(t *testing.T) {
+	obj := map[string]interface{}{
+		"replicas": 3,
+		"finalizers": []interface{}{
+			"kubernetes.io/foo",
+			"kubernetes.io/bar",
+		},
+		"containers": []interface{}{
+			map[string]interface{}{"name": "app", "image": "nginx"},
+			map[string]interface{}{"name": "sidecar", "image": "envoy"},
+		},
+	}
+
+	node := map[string]interface{}{
+		"f:replicas": map[string]interface{}{".": struct{}{}},
+		"f:finalizers": map[string]interface{}{
+			`v:"kubernetes.io/foo"`: struct{}{},
+		},
+		"f:containers": map[string]interface{}{
+			`k:{"name":"app"}`: map[string]interface{}{".": struct{}{}},
+		},
+	}
+
+	want := map[string]interface{}{
+		"replicas":   3,
+		"finalizers": []interface{}{"kubernetes.io/foo"},
+		"containers": []interface{}{
+			map[string]interface{}{"name": "app", "image": "nginx"},
+		},
+	}
+
+	got := projectFields(obj, node)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("projectFields() = %#v, want %#v", got, want)
+	}
+}
+
+func TestComputeJSONPatch(t *testing.T) {
+	base := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"replicas": int64(1)},
+	}}
+	next := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"replicas": int64(2)},
+	}}
+
+	patch, err := computeJSONPatch(base, next)
+	if err != nil {
+		t.Fatalf("computeJSONPatch() error = %v", err)
+	}
+	if len(patch) == 0 {
+		t.Fatalf("computeJSONPatch() produced no operations for a changed object")
+	}
+
+	patch, err = computeJSONPatch(nil, next)
+	if err != nil {
+		t.Fatalf("computeJSONPatch() with nil base error = %v", err)
+	}
+	if len(patch) == 0 {
+		t.Errorf("computeJSONPatch() with nil base should diff against {} and report the whole object as added")
+	}
+}
+
+func TestWriteJSONMergePatch(t *testing.T) {
+	base := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"replicas": int64(1)},
+	}}
+	next := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"replicas": int64(2)},
+	}}
+
+	var buf strings.Builder
+	writeJSONMergePatch(&buf, "Deployment", "default", "web", "1", "2", base, next)
+	if buf.Len() == 0 {
+		t.Errorf("writeJSONMergePatch() wrote nothing for a changed object")
+	}
+
+	buf.Reset()
+	writeJSONMergePatch(&buf, "Deployment", "default", "web", "1", "1", base, base)
+	if buf.Len() != 0 {
+		t.Errorf("writeJSONMergePatch() = %q, want no output for an unchanged object", buf.String())
+	}
+}